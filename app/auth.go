@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// authChallenge is a parsed "WWW-Authenticate: Bearer realm=..., service=..."
+// header, per RFC 2617's auth-param list (token or quoted-string values,
+// commas inside quotes don't end a param).
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+func parseWWWAuthenticate(header string) (*authChallenge, error) {
+	scheme, rest, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, fmt.Errorf("malformed WWW-Authenticate header: %q", header)
+	}
+
+	params := map[string]string{}
+	for _, param := range splitAuthParams(rest) {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		params[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+	}
+
+	return &authChallenge{scheme: scheme, params: params}, nil
+}
+
+// splitAuthParams splits a comma-separated auth-param list, ignoring commas
+// that fall inside a quoted-string.
+func splitAuthParams(s string) []string {
+	var params []string
+	var inQuotes bool
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				params = append(params, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	params = append(params, strings.TrimSpace(s[start:]))
+	return params
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// RegistryClient talks to a single registry host, transparently handling the
+// Bearer challenge/response flow and caching tokens per scope.
+type RegistryClient struct {
+	registry   string
+	httpClient *http.Client
+	credential *basicCredential
+
+	mu     sync.Mutex
+	tokens map[string]string // scope -> bearer token
+}
+
+type basicCredential struct {
+	username, password string
+}
+
+// maxRedirectHops caps how many redirects a single request will follow,
+// guarding against a misbehaving or malicious registry redirect loop.
+const maxRedirectHops = 10
+
+// NewRegistryClient builds a client for registry, loading any matching basic
+// auth credential out of ~/.docker/config.json.
+func NewRegistryClient(registry string) *RegistryClient {
+	return &RegistryClient{
+		registry:   registry,
+		httpClient: &http.Client{CheckRedirect: checkRedirect},
+		credential: loadDockerConfigCredential(registry),
+		tokens:     map[string]string{},
+	}
+}
+
+// checkRedirect enforces a hop limit and strips the Authorization header
+// once a redirect leaves the original host - registries commonly bounce
+// blob requests to a CDN or presigned storage URL that rejects the
+// registry's own bearer token.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirectHops {
+		return fmt.Errorf("stopped after %d redirects", maxRedirectHops)
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// scopeFor builds the "repository:<name>:pull" scope the registry expects
+// for read access to repo.
+func scopeFor(repo string) string {
+	return fmt.Sprintf("repository:%s:pull", repo)
+}
+
+// Do performs req against the registry, authenticating it with a cached or
+// freshly-challenged bearer token for scope, and retrying once if the
+// registry answers 401 with a fresh challenge (e.g. an expired token).
+func (c *RegistryClient) Do(req *http.Request, scope string) (*http.Response, error) {
+	if token, ok := c.cachedToken(scope); ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+	challengeHeader := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+	if challengeHeader == "" {
+		return nil, fmt.Errorf("registry rejected request with 401 and no WWW-Authenticate challenge")
+	}
+
+	token, err := c.authenticate(challengeHeader, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return c.httpClient.Do(retry)
+}
+
+func (c *RegistryClient) cachedToken(scope string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	token, ok := c.tokens[scope]
+	return token, ok
+}
+
+// authenticate resolves challengeHeader against its advertised realm and
+// caches the resulting token for scope.
+func (c *RegistryClient) authenticate(challengeHeader, scope string) (string, error) {
+	challenge, err := parseWWWAuthenticate(challengeHeader)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(challenge.scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported auth scheme %q", challenge.scheme)
+	}
+
+	realm := challenge.params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("auth challenge is missing realm")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, realm, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build token request: %w", err)
+	}
+	q := req.URL.Query()
+	if service := challenge.params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	if scope == "" {
+		scope = challenge.params["scope"]
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	if c.credential != nil {
+		req.SetBasicAuth(c.credential.username, c.credential.password)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach token endpoint %s: %w", realm, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d: %s", res.StatusCode, body)
+	}
+
+	var apiResponse tokenAPIResponse
+	if err := json.Unmarshal(body, &apiResponse); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	token := apiResponse.Token
+	if token == "" {
+		token = apiResponse.AccessToken
+	}
+
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+
+	return token, nil
+}
+
+// Ping issues an unauthenticated GET /v2/ against the registry and, if it is
+// challenged, pre-authenticates for scope so the first real request doesn't
+// pay the extra round trip.
+func (c *RegistryClient) Ping(scope string) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/v2/", c.registry), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build ping request: %w", err)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach registry %s: %w", c.registry, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil // anonymous pulls are allowed
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return nil // let the caller's real request surface anything else
+	}
+
+	challengeHeader := res.Header.Get("WWW-Authenticate")
+	if challengeHeader == "" {
+		return nil
+	}
+	_, err = c.authenticate(challengeHeader, scope)
+	return err
+}
+
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+}
+
+// loadDockerConfigCredential looks up a basic-auth credential for registry
+// in ~/.docker/config.json, returning nil if there isn't one configured.
+func loadDockerConfigCredential(registry string) *basicCredential {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".docker", "config.json"))
+	if err != nil {
+		return nil
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil
+	}
+
+	entry, ok := cfg.Auths[registry]
+	if !ok {
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return nil
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil
+	}
+	return &basicCredential{username: username, password: password}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheDir returns the root of the on-disk content-addressable cache,
+// defaulting to $XDG_CACHE_HOME/ocigo (or ~/.cache/ocigo when unset).
+func cacheDir() (string, error) {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "ocigo"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve cache directory: %w", err)
+	}
+	return filepath.Join(home, ".cache", "ocigo"), nil
+}
+
+// blobCachePath returns where a blob with the given digest is stored, e.g.
+// blobs/sha256/<hex>.
+func blobCachePath(digest string) (string, error) {
+	root, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	algo, digestHex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed digest %q", digest)
+	}
+	return filepath.Join(root, "blobs", algo, digestHex), nil
+}
+
+// manifestCachePath returns where a manifest resolved to digest is stored,
+// keyed by repo@digest.
+func manifestCachePath(repo, digest string) (string, error) {
+	root, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	_, digestHex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed digest %q", digest)
+	}
+	safeRepo := strings.ReplaceAll(repo, "/", "_")
+	return filepath.Join(root, "manifests", safeRepo+"@"+digestHex), nil
+}
+
+// verifyBlobCache reports whether path exists and its content still hashes
+// to digest; a stale or corrupt cache entry is treated as a miss.
+func verifyBlobCache(path, digest string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false
+	}
+	sum := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	return sum == digest
+}
+
+// storeBlobCache moves a verified download at tmpPath into the cache at
+// cachePath, falling back to a copy when the two live on different
+// filesystems (os.Rename can't cross devices).
+func storeBlobCache(tmpPath, cachePath string) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, cachePath); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(cachePath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	dst.Close()
+
+	if err := os.Rename(dst.Name(), cachePath); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	os.Remove(tmpPath)
+	return nil
+}
+
+// manifestCacheEntry is the on-disk shape of a cached manifest: enough to
+// reconstruct the typed value fetchManifestRaw returned.
+type manifestCacheEntry struct {
+	ContentType string          `json:"contentType"`
+	Body        json.RawMessage `json:"body"`
+}
+
+func readManifestCache(repo, digest string) (interface{}, bool) {
+	path, err := manifestCachePath(repo, digest)
+	if err != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry manifestCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+
+	manifest, err := decodeManifest(entry.ContentType, entry.Body)
+	if err != nil {
+		return nil, false
+	}
+
+	// Refresh the mtime so gc's keep-since window counts from the last pull
+	// that used this entry, not from when it was first written.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return manifest, true
+}
+
+func writeManifestCache(repo, digest, contentType string, body []byte) {
+	path, err := manifestCachePath(repo, digest)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(manifestCacheEntry{ContentType: contentType, Body: body})
+	if err != nil {
+		return
+	}
+	os.WriteFile(path, data, 0644)
+}
+
+// isDigest reports whether reference is a content digest ("sha256:...")
+// rather than a mutable tag - only digests are safe to cache, since a tag
+// can move.
+func isDigest(reference string) bool {
+	return strings.Contains(reference, ":")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// containerizeReexecArg is the hidden argv[0]-style subcommand the binary
+// re-execs itself with to become the container's PID 1, once it's already
+// running inside the fresh namespaces.
+const containerizeReexecArg = "child"
+
+// containerConfig carries everything the re-exec'd child needs to finish
+// setting up the container and launch the requested command.
+type containerConfig struct {
+	rootfs   string
+	command  string
+	args     []string
+	rootless bool
+}
+
+// startContainer re-execs the current binary into fresh PID/MNT/UTS/IPC/NET
+// namespaces (plus USER in rootless mode), forwards signals to it, and
+// blocks until it exits.
+func startContainer(cfg containerConfig) (int, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return -1, fmt.Errorf("failed to resolve own executable: %w", err)
+	}
+
+	childArgs := []string{containerizeReexecArg}
+	if cfg.rootless {
+		childArgs = append(childArgs, "--rootless")
+	}
+	childArgs = append(append(childArgs, cfg.rootfs, cfg.command), cfg.args...)
+
+	cmd := exec.Command(self, childArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	attr := &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWNET,
+	}
+	if cfg.rootless {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	cmd.SysProcAttr = attr
+
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("failed to start container init: %w", err)
+	}
+
+	sigs := make(chan os.Signal, 32)
+	signal.Notify(sigs)
+	defer signal.Stop(sigs)
+	forwarding := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				cmd.Process.Signal(sig)
+			case <-forwarding:
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	close(forwarding)
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// runContainerInit is the entry point when the binary re-execs itself as
+// containerizeReexecArg. At this point we're PID 1 inside the new
+// namespaces: finish setup (hostname, mounts, pivot_root), then run command
+// as our own child so we can keep acting like an init - forwarding signals
+// to it and reaping any zombies reparented to us.
+func runContainerInit(rootfs, command string, args []string, rootless bool) int {
+	if err := syscall.Sethostname([]byte("container")); err != nil {
+		fmt.Printf("sethostname: %v\n", err)
+		return 1
+	}
+
+	if err := pivotInto(rootfs, rootless); err != nil {
+		fmt.Printf("container setup failed: %v\n", err)
+		return 1
+	}
+
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("failed to start %s: %v\n", command, err)
+		return 1
+	}
+
+	sigs := make(chan os.Signal, 32)
+	signal.Notify(sigs)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	// reapZombies is the only one wait4()ing in this process, including for
+	// command's own pid, so it and cmd.Wait can't race to reap the same
+	// child: it hands command's status back over foregroundExit instead of
+	// letting cmd.Wait collect it independently.
+	foregroundExit := make(chan syscall.WaitStatus, 1)
+	stopReaping := make(chan struct{})
+	go reapZombies(cmd.Process.Pid, foregroundExit, stopReaping)
+
+	status := <-foregroundExit
+	close(stopReaping)
+	return status.ExitStatus()
+}
+
+// reapZombies wait4()s on every process reparented to us (the container's
+// PID 1) until told to stop, including command's own pid - command's exit
+// status is sent on foregroundExit rather than left for a separate
+// cmd.Wait() call, since once wait4 collects a pid its status can't be
+// un-reaped or handed to anyone else.
+func reapZombies(foreground int, foregroundExit chan<- syscall.WaitStatus, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if pid == foreground {
+			foregroundExit <- status
+		}
+	}
+}
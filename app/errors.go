@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RegistryErrorCode is one of the distribution spec's well-known error
+// codes (https://distribution.github.io/distribution/spec/api/#errors), as
+// returned in a registry's JSON error envelope.
+type RegistryErrorCode string
+
+const (
+	CodeBlobUnknown     RegistryErrorCode = "BLOB_UNKNOWN"
+	CodeManifestUnknown RegistryErrorCode = "MANIFEST_UNKNOWN"
+	CodeUnauthorized    RegistryErrorCode = "UNAUTHORIZED"
+	CodeDenied          RegistryErrorCode = "DENIED"
+	CodeUnknown         RegistryErrorCode = "UNKNOWN"
+)
+
+// RegistryError is a typed, non-2xx response from a registry, so callers
+// can react to e.g. a missing blob instead of seeing an opaque HTTP status.
+type RegistryError struct {
+	StatusCode int
+	Code       RegistryErrorCode
+	Message    string
+}
+
+func (e *RegistryError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("registry error %s (http %d): %s", e.Code, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("registry error %s (http %d)", e.Code, e.StatusCode)
+}
+
+// Is lets errors.Is(err, ErrBlobNotFound) etc. match regardless of the
+// concrete message or status code carried by err.
+func (e *RegistryError) Is(target error) bool {
+	t, ok := target.(*RegistryError)
+	return ok && t.Code == e.Code
+}
+
+// Sentinel errors for errors.Is comparisons; their StatusCode/Message fields
+// are irrelevant to the match and left zero.
+var (
+	ErrBlobNotFound    = &RegistryError{Code: CodeBlobUnknown}
+	ErrManifestUnknown = &RegistryError{Code: CodeManifestUnknown}
+	ErrUnauthorized    = &RegistryError{Code: CodeUnauthorized}
+)
+
+type registryErrorEnvelope struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// registryErrorFromResponse builds a *RegistryError out of a non-2xx
+// response, parsing the distribution spec's JSON error body when present
+// and falling back to a generic CodeUnknown otherwise.
+func registryErrorFromResponse(statusCode int, body []byte) error {
+	var envelope registryErrorEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Errors) > 0 {
+		first := envelope.Errors[0]
+		return &RegistryError{StatusCode: statusCode, Code: RegistryErrorCode(first.Code), Message: first.Message}
+	}
+
+	code := CodeUnknown
+	if statusCode == 401 {
+		code = CodeUnauthorized
+	}
+	return &RegistryError{StatusCode: statusCode, Code: code, Message: string(body)}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegistryErrorFromResponseParsesEnvelope(t *testing.T) {
+	body := []byte(`{"errors":[{"code":"BLOB_UNKNOWN","message":"blob unknown to registry"}]}`)
+	err := registryErrorFromResponse(404, body)
+
+	re, ok := err.(*RegistryError)
+	if !ok {
+		t.Fatalf("expected *RegistryError, got %T", err)
+	}
+	if re.StatusCode != 404 || re.Code != CodeBlobUnknown || re.Message != "blob unknown to registry" {
+		t.Fatalf("unexpected RegistryError: %+v", re)
+	}
+	if !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("expected errors.Is(err, ErrBlobNotFound) to match")
+	}
+}
+
+func TestRegistryErrorFromResponseFallsBackOnUnparseableBody(t *testing.T) {
+	err := registryErrorFromResponse(401, []byte("not json"))
+
+	re, ok := err.(*RegistryError)
+	if !ok {
+		t.Fatalf("expected *RegistryError, got %T", err)
+	}
+	if re.Code != CodeUnauthorized {
+		t.Fatalf("expected CodeUnauthorized for a 401 with no envelope, got %s", re.Code)
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected errors.Is(err, ErrUnauthorized) to match")
+	}
+}
+
+func TestRegistryErrorIsIgnoresMessageAndStatus(t *testing.T) {
+	a := &RegistryError{StatusCode: 404, Code: CodeManifestUnknown, Message: "missing"}
+	b := &RegistryError{StatusCode: 500, Code: CodeManifestUnknown, Message: "different message"}
+	if !errors.Is(a, b) {
+		t.Fatal("expected two RegistryErrors with the same Code to match regardless of status/message")
+	}
+	if errors.Is(a, ErrBlobNotFound) {
+		t.Fatal("expected RegistryErrors with different codes not to match")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultGCKeepSince is how long a cached manifest (and the blobs it
+// references) survives without being re-pulled before gc considers it
+// stale.
+const defaultGCKeepSince = 7 * 24 * time.Hour
+
+// runGC implements `ocigo gc --keep-since=<duration>`: it expires cached
+// manifests older than the keep-since window, then deletes any cached blob
+// no longer referenced by a surviving manifest.
+func runGC(args []string) error {
+	keepSince := defaultGCKeepSince
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--keep-since="); ok {
+			d, err := parseKeepSince(value)
+			if err != nil {
+				return err
+			}
+			keepSince = d
+		}
+	}
+
+	root, err := cacheDir()
+	if err != nil {
+		return err
+	}
+
+	referenced, err := expireManifests(filepath.Join(root, "manifests"), time.Now().Add(-keepSince))
+	if err != nil {
+		return err
+	}
+
+	removed, kept, err := sweepBlobs(filepath.Join(root, "blobs"), referenced)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("gc: removed %d blob(s), kept %d referenced blob(s)\n", removed, kept)
+	return nil
+}
+
+// expireManifests deletes any cached manifest last touched before cutoff
+// and returns the set of digests (config + layers) referenced by the ones
+// that survive.
+func expireManifests(dir string, cutoff time.Time) (map[string]bool, error) {
+	referenced := map[string]bool{}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return referenced, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			os.Remove(path)
+			continue
+		}
+		for _, digest := range manifestDigests(path) {
+			referenced[digest] = true
+		}
+	}
+	return referenced, nil
+}
+
+// sweepBlobs deletes any cached blob whose digest isn't in referenced.
+func sweepBlobs(dir string, referenced map[string]bool) (removed, kept int, err error) {
+	algoDirs, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, algoDir := range algoDirs {
+		algoPath := filepath.Join(dir, algoDir.Name())
+		blobs, err := os.ReadDir(algoPath)
+		if err != nil {
+			continue
+		}
+		for _, blob := range blobs {
+			digest := algoDir.Name() + ":" + blob.Name()
+			if referenced[digest] {
+				kept++
+				continue
+			}
+			os.Remove(filepath.Join(algoPath, blob.Name()))
+			removed++
+		}
+	}
+	return removed, kept, nil
+}
+
+// manifestDigests reads the config and layer digests out of a cached
+// manifest file, or nil if it can't be decoded.
+func manifestDigests(manifestPath string) []string {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil
+	}
+
+	var entry manifestCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+
+	manifest, err := decodeManifest(entry.ContentType, entry.Body)
+	if err != nil {
+		return nil
+	}
+
+	v2, ok := manifest.(*ManifestV2)
+	if !ok {
+		return nil
+	}
+
+	digests := make([]string, 0, len(v2.Layers)+1)
+	digests = append(digests, v2.Config.Digest)
+	for _, l := range v2.Layers {
+		digests = append(digests, l.Digest)
+	}
+	return digests
+}
+
+// parseKeepSince accepts both Go's native duration syntax ("168h") and a
+// "<N>d" shorthand for days, since that's how most people reason about a
+// retention window.
+func parseKeepSince(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --keep-since duration %q: %w", value, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --keep-since duration %q: %w", value, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseKeepSince(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "168h", want: 168 * time.Hour},
+		{value: "10m", want: 10 * time.Minute},
+		{value: "not-a-duration", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseKeepSince(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseKeepSince(%q): expected error, got %v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseKeepSince(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseKeepSince(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func writeFakeManifest(t *testing.T, path string, config string, layers []string) {
+	t.Helper()
+	body, err := json.Marshal(ManifestV2{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifestV2,
+		Config:        Descriptor{Digest: config},
+		Layers:        descriptorsFor(layers),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := json.Marshal(manifestCacheEntry{ContentType: mediaTypeManifestV2, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, entry, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func descriptorsFor(digests []string) []Descriptor {
+	out := make([]Descriptor, len(digests))
+	for i, d := range digests {
+		out[i] = Descriptor{Digest: d}
+	}
+	return out
+}
+
+func TestExpireManifestsAndSweepBlobs(t *testing.T) {
+	root := t.TempDir()
+	manifestsDir := filepath.Join(root, "manifests")
+	blobsDir := filepath.Join(root, "blobs", "sha256")
+	if err := os.MkdirAll(manifestsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	freshPath := filepath.Join(manifestsDir, "fresh@sha256-1")
+	writeFakeManifest(t, freshPath, "sha256:aaa", []string{"sha256:bbb"})
+
+	stalePath := filepath.Join(manifestsDir, "stale@sha256-2")
+	writeFakeManifest(t, stalePath, "sha256:ccc", nil)
+	stale := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(stalePath, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, digest := range []string{"aaa", "bbb", "ccc"} {
+		if err := os.WriteFile(filepath.Join(blobsDir, digest), []byte(digest), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	referenced, err := expireManifests(manifestsDir, cutoff)
+	if err != nil {
+		t.Fatalf("expireManifests: %v", err)
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Fatalf("expected stale manifest to be removed, stat err = %v", err)
+	}
+	if _, err := os.Stat(freshPath); err != nil {
+		t.Fatalf("expected fresh manifest to survive: %v", err)
+	}
+	if !referenced["sha256:aaa"] || !referenced["sha256:bbb"] {
+		t.Fatalf("expected fresh manifest's digests referenced, got %v", referenced)
+	}
+	if referenced["sha256:ccc"] {
+		t.Fatalf("expired manifest's digest must not be referenced, got %v", referenced)
+	}
+
+	removed, kept, err := sweepBlobs(filepath.Join(root, "blobs"), referenced)
+	if err != nil {
+		t.Fatalf("sweepBlobs: %v", err)
+	}
+	if removed != 1 || kept != 2 {
+		t.Fatalf("sweepBlobs: removed=%d kept=%d, want removed=1 kept=2", removed, kept)
+	}
+	if _, err := os.Stat(filepath.Join(blobsDir, "ccc")); !os.IsNotExist(err) {
+		t.Fatal("expected unreferenced blob ccc to be removed")
+	}
+	for _, digest := range []string{"aaa", "bbb"} {
+		if _, err := os.Stat(filepath.Join(blobsDir, digest)); err != nil {
+			t.Fatalf("expected referenced blob %s to survive: %v", digest, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	whiteoutPrefix  = ".wh."
+	opaqueWhiteout  = ".wh..wh..opq"
+	maxLayerWorkers = 4
+)
+
+// extractImage downloads manifest's layers concurrently (network is the
+// bottleneck, and digest verification is independent per layer) but applies
+// them to dest sequentially in manifest order, since each layer may shadow
+// or whiteout files from the one below it. Downloaded blobs land in the
+// content-addressable cache and are left there for the next pull of the
+// same image.
+func extractImage(dest string, client *RegistryClient, repo string, manifest *ManifestV2) error {
+	blobs, err := downloadLayers(client, repo, manifest.Layers)
+	if err != nil {
+		return err
+	}
+	defer removeEphemeralBlobs(blobs)
+
+	for i, layer := range manifest.Layers {
+		if err := extractLayer(dest, blobs[i].path, layer.MediaType); err != nil {
+			return fmt.Errorf("failed to extract layer %s: %w", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+// downloadedLayer is a local path to a layer's verified content, plus
+// whether that path is a scratch temp file downloadLayerBlob couldn't move
+// into the persistent cache (e.g. cacheDir failed) - ephemeral paths are the
+// caller's responsibility to remove once they've been extracted.
+type downloadedLayer struct {
+	path      string
+	ephemeral bool
+}
+
+func removeEphemeralBlobs(blobs []downloadedLayer) {
+	for _, b := range blobs {
+		if b.ephemeral {
+			os.Remove(b.path)
+		}
+	}
+}
+
+// downloadLayers fetches layers through a small worker pool and returns
+// their blob paths in manifest order.
+func downloadLayers(client *RegistryClient, repo string, layers []Descriptor) ([]downloadedLayer, error) {
+	blobs := make([]downloadedLayer, len(layers))
+	errs := make([]error, len(layers))
+
+	sem := make(chan struct{}, maxLayerWorkers)
+	var wg sync.WaitGroup
+	for i, layer := range layers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, layer Descriptor) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			blobs[i], errs[i] = downloadLayerBlob(client, repo, layer)
+		}(i, layer)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			removeEphemeralBlobs(blobs)
+			return nil, fmt.Errorf("failed to download layer %s: %w", layers[i].Digest, err)
+		}
+	}
+	return blobs, nil
+}
+
+// maxDownloadAttempts caps the total number of attempts at a layer download,
+// the first one included; each retry resumes via Range where the registry
+// allows it, picking up after a transient network failure mid-stream.
+const maxDownloadAttempts = 3
+
+// downloadLayerBlob returns layer's verified content, pulling it from the
+// on-disk cache when present and otherwise downloading it fresh and caching
+// the result for next time. When it can't be moved into the persistent cache
+// (no cache dir, or storeBlobCache itself fails), the returned blob is
+// marked ephemeral so the caller cleans up the scratch temp file instead of
+// leaking it.
+func downloadLayerBlob(client *RegistryClient, repo string, layer Descriptor) (downloadedLayer, error) {
+	cachePath, cacheErr := blobCachePath(layer.Digest)
+	if cacheErr == nil && verifyBlobCache(cachePath, layer.Digest) {
+		return downloadedLayer{path: cachePath}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "ocigo-layer-*")
+	if err != nil {
+		return downloadedLayer{}, err
+	}
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	var downloaded int64
+	var lastErr error
+	for attempt := 0; attempt < maxDownloadAttempts; attempt++ {
+		n, err := downloadLayerAttempt(client, repo, layer, tmp, hasher, downloaded)
+		downloaded += n
+		if err == nil {
+			lastErr = nil
+			break
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		os.Remove(tmp.Name())
+		return downloadedLayer{}, fmt.Errorf("failed to download layer after %d attempts: %w", maxDownloadAttempts, lastErr)
+	}
+
+	if sum := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); sum != layer.Digest {
+		os.Remove(tmp.Name())
+		return downloadedLayer{}, fmt.Errorf("digest mismatch: expected %s, got %s", layer.Digest, sum)
+	}
+
+	if cacheErr == nil {
+		if err := storeBlobCache(tmp.Name(), cachePath); err == nil {
+			return downloadedLayer{path: cachePath}, nil
+		}
+	}
+	return downloadedLayer{path: tmp.Name(), ephemeral: true}, nil
+}
+
+// downloadLayerAttempt fetches layer's blob into tmp (resuming from
+// resumeFrom bytes via a Range header if resumeFrom > 0), feeding every byte
+// through hasher as it's written. It returns the number of bytes it wrote,
+// which the caller adds to its running offset even on a partial failure so
+// the next attempt resumes from the right place.
+func downloadLayerAttempt(client *RegistryClient, repo string, layer Descriptor, tmp *os.File, hasher hash.Hash, resumeFrom int64) (int64, error) {
+	url := fmt.Sprintf("https://%s/v2/%s/blobs/%s", client.registry, repo, layer.Digest)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build blob request: %w", err)
+	}
+	if resumeFrom > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+	}
+
+	res, err := client.Do(req, scopeFor(repo))
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch layer: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case resumeFrom > 0 && res.StatusCode == http.StatusPartialContent:
+		// registry honored the Range request; keep appending to tmp.
+	case resumeFrom > 0:
+		return 0, fmt.Errorf("registry does not support resuming this download (status %d)", res.StatusCode)
+	case res.StatusCode == http.StatusNotFound:
+		body, _ := io.ReadAll(res.Body)
+		err := registryErrorFromResponse(res.StatusCode, body)
+		if re, ok := err.(*RegistryError); ok {
+			re.Code = CodeBlobUnknown
+		}
+		return 0, err
+	case res.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(res.Body)
+		return 0, registryErrorFromResponse(res.StatusCode, body)
+	}
+
+	return io.Copy(tmp, io.TeeReader(res.Body, hasher))
+}
+
+// extractLayer untars the blob at path into dest, decompressing it first
+// according to mediaType, and honoring OCI whiteout conventions for files
+// removed by this layer.
+func extractLayer(dest, path, mediaType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.Contains(mediaType, "zstd"):
+		return fmt.Errorf("zstd-compressed layers are not yet supported (media type %s)", mediaType)
+	case strings.Contains(mediaType, "gzip"), mediaType == "":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip layer: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+		if err := applyTarEntry(dest, header, tr); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", header.Name, err)
+		}
+	}
+}
+
+// applyTarEntry writes a single tar entry under dest, or, for the OCI
+// whiteout conventions (".wh.<name>" and ".wh..wh..opq"), removes the
+// corresponding path from the lower layers instead.
+func applyTarEntry(dest string, header *tar.Header, r io.Reader) error {
+	name := filepath.Clean(header.Name)
+	if err := ensureWithinRoot(dest, name); err != nil {
+		return err
+	}
+	dir, base := filepath.Split(name)
+
+	if base == opaqueWhiteout {
+		return clearDir(filepath.Join(dest, dir))
+	}
+	if strings.HasPrefix(base, whiteoutPrefix) {
+		return os.RemoveAll(filepath.Join(dest, dir, strings.TrimPrefix(base, whiteoutPrefix)))
+	}
+
+	target := filepath.Join(dest, name)
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+		if err != nil {
+			return err
+		}
+		_, copyErr := io.Copy(out, r)
+		out.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+	case tar.TypeSymlink:
+		os.Remove(target)
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+		return nil // symlinks carry no separate ownership/mtime in the tar stream worth restoring
+	case tar.TypeLink:
+		linkName := filepath.Clean(header.Linkname)
+		if err := ensureWithinRoot(dest, linkName); err != nil {
+			return err
+		}
+		os.Remove(target)
+		if err := os.Link(filepath.Join(dest, linkName), target); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := mknod(target, header); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	os.Lchown(target, header.Uid, header.Gid)
+	os.Chtimes(target, header.AccessTime, header.ModTime)
+	for k, v := range header.Xattrs {
+		setXattr(target, k, v)
+	}
+	return nil
+}
+
+// ensureWithinRoot rejects any entry whose name (already filepath.Clean'd)
+// would resolve outside dest once joined to it - the standard "tar slip"
+// guard. Without it, a crafted entry name or hardlink target (e.g.
+// "../../etc/passwd") lets a pulled layer write or overwrite arbitrary files
+// anywhere the current user can write; digest verification doesn't help here
+// since the attacker controls the content the digest is computed over.
+func ensureWithinRoot(dest, name string) error {
+	target := filepath.Join(dest, name)
+	root := filepath.Clean(dest)
+	if target != root && !strings.HasPrefix(target, root+string(filepath.Separator)) {
+		return fmt.Errorf("illegal path %q escapes extraction root", name)
+	}
+	return nil
+}
+
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// mknod creates the device/fifo special file described by header, since
+// archive/tar has no portable way to do this itself.
+func mknod(path string, header *tar.Header) error {
+	mode := uint32(header.Mode)
+	switch header.Typeflag {
+	case tar.TypeChar:
+		mode |= syscall.S_IFCHR
+	case tar.TypeBlock:
+		mode |= syscall.S_IFBLK
+	case tar.TypeFifo:
+		mode |= syscall.S_IFIFO
+	}
+
+	dev := makedev(uint32(header.Devmajor), uint32(header.Devminor))
+	if err := syscall.Mknod(path, mode, int(dev)); err != nil {
+		return fmt.Errorf("mknod %s: %w", path, err)
+	}
+	return nil
+}
+
+// makedev packs a (major, minor) pair into the single dev_t value
+// syscall.Mknod expects, the way glibc's makedev(3) traditionally did.
+// syscall.Mkdev doesn't exist in the standard library - that helper lives in
+// golang.org/x/sys/unix, which this tree has no go.mod to vendor.
+func makedev(major, minor uint32) uint64 {
+	return (uint64(major) << 8) | uint64(minor)
+}
+
+// setXattr restores a single extended attribute captured in the tar header.
+// archive/tar and the standard syscall package have no portable xattr
+// helper, so this drops to the raw syscall.
+func setXattr(path, name, value string) error {
+	pathPtr, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	namePtr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	var valuePtr unsafe.Pointer
+	if len(value) > 0 {
+		valuePtr = unsafe.Pointer(&[]byte(value)[0])
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR,
+		uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(namePtr)),
+		uintptr(valuePtr), uintptr(len(value)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
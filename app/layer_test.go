@@ -0,0 +1,70 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyTarEntryWhiteoutRemovesFile(t *testing.T) {
+	dest := t.TempDir()
+	lower := filepath.Join(dest, "etc")
+	if err := os.MkdirAll(lower, 0755); err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(lower, "passwd")
+	if err := os.WriteFile(victim, []byte("root"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	header := &tar.Header{Name: "etc/.wh.passwd", Typeflag: tar.TypeReg}
+	if err := applyTarEntry(dest, header, nil); err != nil {
+		t.Fatalf("applyTarEntry: %v", err)
+	}
+	if _, err := os.Stat(victim); !os.IsNotExist(err) {
+		t.Fatalf("expected %s removed by whiteout, stat err = %v", victim, err)
+	}
+}
+
+func TestApplyTarEntryOpaqueWhiteoutClearsDir(t *testing.T) {
+	dest := t.TempDir()
+	dir := filepath.Join(dest, "data")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	header := &tar.Header{Name: "data/.wh..wh..opq", Typeflag: tar.TypeReg}
+	if err := applyTarEntry(dest, header, nil); err != nil {
+		t.Fatalf("applyTarEntry: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected %s cleared by opaque whiteout, found %v", dir, entries)
+	}
+}
+
+func TestApplyTarEntryRejectsPathTraversal(t *testing.T) {
+	dest := t.TempDir()
+	header := &tar.Header{Name: "../../etc/passwd", Typeflag: tar.TypeReg, Mode: 0644}
+	if err := applyTarEntry(dest, header, nil); err == nil {
+		t.Fatal("expected applyTarEntry to reject an entry name escaping dest")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "..", "..", "etc", "passwd")); !os.IsNotExist(err) {
+		t.Fatal("entry must not have been written outside dest")
+	}
+}
+
+func TestApplyTarEntryRejectsHardlinkTraversal(t *testing.T) {
+	dest := t.TempDir()
+	header := &tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}
+	if err := applyTarEntry(dest, header, nil); err == nil {
+		t.Fatal("expected applyTarEntry to reject a hardlink target escaping dest")
+	}
+}
@@ -1,14 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path"
-	"syscall"
+	"strings"
 	"time"
 )
 
@@ -19,71 +16,91 @@ type tokenAPIResponse struct {
 	IssuedAt    time.Time `json:"issued_at"`
 }
 
-type FsLayer struct {
-	BlobSum string `json:"blobSum"`
-}
-
-type Manifest struct {
-	Name     string    `json:"name"`
-	Tag      string    `json:"tag"`
-	FsLayers []FsLayer `json:"fsLayers"`
-}
-
-// Usage: your_docker.sh run <image> <command> <arg1> <arg2> ...
+// Usage:
+//   your_docker.sh run [--platform=os/arch] [--rootless] <image> <command> <arg1> <arg2> ...
+//   your_docker.sh gc [--keep-since=7d]
 func main() {
-	image := os.Args[2]
-	command := os.Args[3]
-	args := os.Args[4:len(os.Args)]
+	if os.Args[1] == "gc" {
+		if err := runGC(os.Args[2:]); err != nil {
+			fmt.Printf("gc error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if os.Args[1] == containerizeReexecArg {
+		reexecArgs, rootless := os.Args[2:], false
+		if reexecArgs[0] == "--rootless" {
+			rootless, reexecArgs = true, reexecArgs[1:]
+		}
+		rootfs, command, args := reexecArgs[0], reexecArgs[1], reexecArgs[2:]
+		os.Exit(runContainerInit(rootfs, command, args, rootless))
+	}
 
-	chrootDir, err := os.MkdirTemp("", "")
+	rest, override, rootless := parseRunFlags(os.Args[2:])
+	ref := parseImageReference(rest[0])
+	command := rest[1]
+	args := rest[2:]
 
-	token, err := getBearerToken(image)
+	rootfs, err := os.MkdirTemp("", "")
 	if err != nil {
-		fmt.Printf("error getting token: %v", err)
+		fmt.Printf("error creating rootfs dir: %v", err)
 		os.Exit(1)
 	}
 
-	manifest, err := fetchManifest(token, image)
+	client := NewRegistryClient(ref.Registry)
+	scope := scopeFor(ref.Repository)
+	if err := client.Ping(scope); err != nil {
+		fmt.Printf("error authenticating with %s: %v", ref.Registry, err)
+		os.Exit(1)
+	}
+
+	manifest, err := fetchManifest(client, ref.Repository, ref.Reference(), override)
 	if err != nil {
 		fmt.Printf("error fetching manifest: %v", err)
 		os.Exit(1)
 	}
 
-	if err := extractImage(chrootDir, token, image, manifest); err != nil {
+	if err := extractImage(rootfs, client, ref.Repository, manifest); err != nil {
 		fmt.Printf("error extracting image: %v", err)
 		os.Exit(1)
 	}
 
-	if err := copyExecutableIntoDir(chrootDir, command); err != nil {
+	if err := copyExecutableIntoDir(rootfs, command); err != nil {
 		fmt.Printf("error copy executable: %v", err)
 		os.Exit(1)
 	}
 
-	if err := createDevNull(chrootDir); err != nil {
-		fmt.Printf("error creating /dev/null: %v", err)
-		os.Exit(1)
-	}
-
-	if err := syscall.Chroot(chrootDir); err != nil {
-		fmt.Printf("chroot err: %v", err)
+	exitCode, err := startContainer(containerConfig{rootfs: rootfs, command: command, args: args, rootless: rootless})
+	if err != nil {
+		fmt.Printf("error running container: %v", err)
 		os.Exit(1)
 	}
+	os.Exit(exitCode)
+}
 
-	cmd := exec.Command(command, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWPID,
-	}
-
-	err = cmd.Run()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		os.Exit(exitError.ExitCode())
-	} else if err != nil {
-		fmt.Printf("Err: %v", err)
-		os.Exit(1)
+// parseRunFlags pulls any leading "--platform=os/arch" and "--rootless"
+// flags off args, returning the remaining positional arguments alongside
+// the options they described.
+func parseRunFlags(args []string) ([]string, platformOverride, bool) {
+	var override platformOverride
+	var rootless bool
+
+	for len(args) > 0 {
+		switch {
+		case strings.HasPrefix(args[0], "--platform="):
+			value := strings.TrimPrefix(args[0], "--platform=")
+			os, arch, _ := strings.Cut(value, "/")
+			override = platformOverride{os: os, arch: arch}
+			args = args[1:]
+		case args[0] == "--rootless":
+			rootless = true
+			args = args[1:]
+		default:
+			return args, override, rootless
+		}
 	}
-
+	return args, override, rootless
 }
 
 func copyExecutableIntoDir(chrootDir string, executablePath string) error {
@@ -127,112 +144,3 @@ func CopyFile(sourceFilePath string, destinationFilePath string) error {
 	return err
 }
 
-func createDevNull(chrootDir string) error {
-	if err := os.MkdirAll(path.Join(chrootDir, "dev"), 0750); err != nil {
-		return err
-	}
-
-	return os.WriteFile(path.Join(chrootDir, "dev", "null"), []byte{}, 0644)
-}
-
-func getBearerToken(repo string) (string, error) {
-	var apiResponse tokenAPIResponse
-	service := "registry.docker.io"
-	url := fmt.Sprintf("http://auth.docker.io/token?service=%s&scope=repository:library/%s:pull", service, repo)
-	response, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to call http://auth.docker.io/token: %w", err)
-	}
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read http response body: %w", err)
-	}
-
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return "", fmt.Errorf("failed to parse http response: %w", err)
-	}
-
-	return apiResponse.Token, nil
-}
-
-func fetchManifest(token, repo string) (*Manifest, error) {
-	tag := "latest"
-	url := fmt.Sprintf("https://registry-1.docker.io/v2/library/%s/manifests/%s", repo, tag)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read http response body: %w", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read http response body: %w", err)
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read http response body: %w", err)
-	}
-
-	var manifest Manifest
-	err = json.Unmarshal(body, &manifest)
-	return &manifest, err
-}
-
-func extractImage(dest, token, repo string, manifest *Manifest) error {
-	for _, fsLayer := range manifest.FsLayers {
-		if err := fetchLayer(dest, token, repo, fsLayer); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func fetchLayer(dest, token, repo string, fsLayer FsLayer) error {
-	var res *http.Response
-	url := fmt.Sprintf("https://registry-1.docker.io/v2/library/%s/blobs/%s", repo, fsLayer.BlobSum)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to read http response body: %w", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	res, err = http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to read http response body: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == 307 {
-		redirectUrl := res.Header.Get("location")
-		req, err := http.NewRequest(http.MethodGet, redirectUrl, nil)
-		if err != nil {
-			return fmt.Errorf("failed to read http response body: %w", err)
-		}
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-
-		res, err = http.DefaultClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to read http response body: %w", err)
-		}
-		defer res.Body.Close()
-	}
-
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read http response body: %w", err)
-	}
-
-	tarball := fmt.Sprintf("%s.tar", fsLayer.BlobSum)
-	if err := os.WriteFile(tarball, data, 0644); err != nil {
-		return err
-	}
-	defer os.Remove(tarball)
-
-	cmd := exec.Command("tar", "xpf", tarball, "-C", dest)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
-}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRunFlags(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantRest     []string
+		wantOverride platformOverride
+		wantRootless bool
+	}{
+		{
+			name:     "no flags",
+			args:     []string{"redis", "echo", "hi"},
+			wantRest: []string{"redis", "echo", "hi"},
+		},
+		{
+			name:         "platform only",
+			args:         []string{"--platform=linux/arm64", "redis"},
+			wantRest:     []string{"redis"},
+			wantOverride: platformOverride{os: "linux", arch: "arm64"},
+		},
+		{
+			name:         "rootless only",
+			args:         []string{"--rootless", "redis"},
+			wantRest:     []string{"redis"},
+			wantRootless: true,
+		},
+		{
+			name:         "rootless before platform",
+			args:         []string{"--rootless", "--platform=linux/amd64", "redis", "sh"},
+			wantRest:     []string{"redis", "sh"},
+			wantOverride: platformOverride{os: "linux", arch: "amd64"},
+			wantRootless: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, override, rootless := parseRunFlags(tt.args)
+			if !reflect.DeepEqual(rest, tt.wantRest) {
+				t.Errorf("rest = %v, want %v", rest, tt.wantRest)
+			}
+			if override != tt.wantOverride {
+				t.Errorf("override = %+v, want %+v", override, tt.wantOverride)
+			}
+			if rootless != tt.wantRootless {
+				t.Errorf("rootless = %v, want %v", rootless, tt.wantRootless)
+			}
+		})
+	}
+}
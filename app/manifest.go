@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+)
+
+const (
+	mediaTypeManifestV1   = "application/vnd.docker.distribution.manifest.v1+json"
+	mediaTypeManifestV2   = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAcceptHeader is sent on every manifest fetch so the registry knows
+// we understand schema2 and OCI. Legacy schema1 is listed last as well, but a
+// schema1 response is rejected by decodeManifest as an unexpected Content-Type.
+var manifestAcceptHeader = fmt.Sprintf("%s,%s,%s,%s,%s",
+	mediaTypeManifestV2, mediaTypeManifestList, mediaTypeOCIManifest, mediaTypeOCIIndex, mediaTypeManifestV1)
+
+// Platform identifies the OS/architecture a manifest list entry targets.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Matches reports whether p satisfies the requested os/arch.
+func (p Platform) Matches(os, arch string) bool {
+	return p.OS == os && p.Architecture == arch
+}
+
+// Descriptor points at a content-addressable blob: a layer, a config, or a
+// nested manifest when it appears inside a manifest list / OCI index.
+type Descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	Platform  Platform `json:"platform,omitempty"`
+}
+
+// ManifestV2 is the docker schema2 / OCI image manifest shape: a config blob
+// plus an ordered list of layer blobs.
+type ManifestV2 struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ManifestList is the docker schema2 manifest list: one Descriptor per
+// platform, each pointing at a ManifestV2.
+type ManifestList struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// OCIIndex is the OCI equivalent of ManifestList.
+type OCIIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// platformOverride lets callers pin the platform selected out of a manifest
+// list instead of defaulting to the host's GOOS/GOARCH.
+type platformOverride struct {
+	os, arch string
+}
+
+func (o platformOverride) resolve() (string, string) {
+	os, arch := runtime.GOOS, runtime.GOARCH
+	if o.os != "" {
+		os = o.os
+	}
+	if o.arch != "" {
+		arch = o.arch
+	}
+	return os, arch
+}
+
+// fetchManifest retrieves the manifest for repo:tag, following a manifest
+// list / OCI index down to the entry matching override (or the host
+// platform) and returning the resolved schema2/OCI manifest.
+func fetchManifest(client *RegistryClient, repo, tag string, override platformOverride) (*ManifestV2, error) {
+	manifest, err := fetchManifestRaw(client, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m := manifest.(type) {
+	case *ManifestV2:
+		return m, nil
+	case *ManifestList:
+		return fetchManifestListEntry(client, repo, m.Manifests, override)
+	case *OCIIndex:
+		return fetchManifestListEntry(client, repo, m.Manifests, override)
+	default:
+		return nil, fmt.Errorf("unsupported manifest type for %s:%s; schema1 images are no longer supported", repo, tag)
+	}
+}
+
+func fetchManifestListEntry(client *RegistryClient, repo string, manifests []Descriptor, override platformOverride) (*ManifestV2, error) {
+	digest, err := selectPlatform(manifests, override)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := fetchManifestRaw(client, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	v2, ok := resolved.(*ManifestV2)
+	if !ok {
+		return nil, fmt.Errorf("manifest list entry %s did not resolve to a schema2/OCI manifest", digest)
+	}
+	return v2, nil
+}
+
+func selectPlatform(manifests []Descriptor, override platformOverride) (string, error) {
+	wantOS, wantArch := override.resolve()
+	for _, d := range manifests {
+		if d.Platform.Matches(wantOS, wantArch) {
+			return d.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("no manifest found for platform %s/%s", wantOS, wantArch)
+}
+
+// fetchManifestRaw fetches repo:reference (tag or digest) and unmarshals it
+// according to the Content-Type the registry answered with, returning the
+// concrete manifest value: one of *ManifestV2, *ManifestList, or *OCIIndex.
+// References that are already a digest are served out of the on-disk cache
+// when possible. Only a resolved schema2/OCI manifest is written to the cache
+// (manifest lists and OCI indexes are not), and it is always cached under its content digest, even when reference was a
+// mutable tag, since that's the digest gc's blob-reference accounting keys
+// off of.
+func fetchManifestRaw(client *RegistryClient, repo, reference string) (interface{}, error) {
+	if isDigest(reference) {
+		if cached, ok := readManifestCache(repo, reference); ok {
+			return cached, nil
+		}
+	}
+
+	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", client.registry, repo, reference)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build manifest request: %w", err)
+	}
+	req.Header.Set("Accept", manifestAcceptHeader)
+
+	res, err := client.Do(req, scopeFor(repo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, registryErrorFromResponse(res.StatusCode, body)
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	manifest, err := decodeManifest(contentType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := manifest.(*ManifestV2); ok {
+		writeManifestCache(repo, manifestDigest(res, body), contentType, body)
+	}
+	return manifest, nil
+}
+
+// manifestDigest returns the content digest a resolved manifest should be
+// cached under: the registry's own Docker-Content-Digest when it sent one,
+// falling back to the sha256 of the response body (which is what the
+// registry's digest is defined to be anyway) so a tag pull caches just as
+// reliably as a digest pull.
+func manifestDigest(res *http.Response, body []byte) string {
+	if digest := res.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// decodeManifest unmarshals body into the concrete manifest type for
+// contentType, the shared codec used both for a live registry response and
+// a cached one read back off disk.
+func decodeManifest(contentType string, body []byte) (interface{}, error) {
+	switch contentType {
+	case mediaTypeManifestV2, mediaTypeOCIManifest:
+		var m ManifestV2
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+		return &m, nil
+	case mediaTypeManifestList:
+		var l ManifestList
+		if err := json.Unmarshal(body, &l); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest list: %w", err)
+		}
+		return &l, nil
+	case mediaTypeOCIIndex:
+		var idx OCIIndex
+		if err := json.Unmarshal(body, &idx); err != nil {
+			return nil, fmt.Errorf("failed to parse OCI index: %w", err)
+		}
+		return &idx, nil
+	default:
+		return nil, fmt.Errorf("unexpected manifest Content-Type %q", contentType)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// pivotInto mounts a fresh /proc (and, for privileged containers, /sys and
+// a handful of /dev device nodes) under rootfs, then pivot_roots into it and
+// detaches the old root - the namespace-native replacement for
+// syscall.Chroot.
+func pivotInto(rootfs string, rootless bool) error {
+	// Reparent the mount tree as private first so none of the mounts below
+	// (or the pivot_root itself) propagate back out to the host.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mount tree private: %w", err)
+	}
+
+	// pivot_root requires new_root to be a mount point, and rootfs is a plain
+	// directory, so bind-mount it onto itself first.
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind-mount rootfs: %w", err)
+	}
+
+	if err := mountProc(rootfs); err != nil {
+		return err
+	}
+
+	// sysfs and devtmpfs-style nodes need CAP_SYS_ADMIN over the namespace
+	// that owns the network namespace we just created, which a single-ID
+	// rootless mapping doesn't have - skip them rather than fail the run.
+	if !rootless {
+		if err := mountSys(rootfs); err != nil {
+			return err
+		}
+		if err := mountDev(rootfs); err != nil {
+			return err
+		}
+	}
+
+	oldRoot := filepath.Join(rootfs, ".oldroot")
+	if err := os.MkdirAll(oldRoot, 0700); err != nil {
+		return err
+	}
+
+	if err := syscall.PivotRoot(rootfs, oldRoot); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+
+	if err := os.Chdir("/"); err != nil {
+		return fmt.Errorf("chdir to new root: %w", err)
+	}
+
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach old root: %w", err)
+	}
+	return os.RemoveAll("/.oldroot")
+}
+
+func mountProc(rootfs string) error {
+	target := filepath.Join(rootfs, "proc")
+	if err := os.MkdirAll(target, 0555); err != nil {
+		return err
+	}
+	return syscall.Mount("proc", target, "proc", 0, "")
+}
+
+func mountSys(rootfs string) error {
+	target := filepath.Join(rootfs, "sys")
+	if err := os.MkdirAll(target, 0555); err != nil {
+		return err
+	}
+	return syscall.Mount("sysfs", target, "sysfs", 0, "")
+}
+
+// mountDev populates rootfs/dev with the handful of device nodes most
+// container payloads expect to find, replacing the old single /dev/null
+// placeholder.
+func mountDev(rootfs string) error {
+	devDir := filepath.Join(rootfs, "dev")
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		return err
+	}
+
+	nodes := []struct {
+		name         string
+		major, minor uint32
+	}{
+		{"null", 1, 3},
+		{"zero", 1, 5},
+		{"random", 1, 8},
+		{"urandom", 1, 9},
+		{"tty", 5, 0},
+	}
+	for _, n := range nodes {
+		path := filepath.Join(devDir, n.name)
+		dev := makedev(n.major, n.minor)
+		if err := syscall.Mknod(path, syscall.S_IFCHR|0666, int(dev)); err != nil {
+			return fmt.Errorf("mknod /dev/%s: %w", n.name, err)
+		}
+	}
+	return nil
+}
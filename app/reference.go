@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+const (
+	defaultRegistry  = "registry-1.docker.io"
+	defaultNamespace = "library"
+	defaultTag       = "latest"
+)
+
+// ImageReference is a parsed "[registry/]repository[:tag|@digest]" string,
+// e.g. "ghcr.io/owner/app:v2" or the bare "redis" (which expands to
+// registry-1.docker.io/library/redis:latest).
+type ImageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageReference splits image into its registry, repository, and
+// tag/digest components, applying Docker Hub's defaults when they're
+// omitted. A component before the first "/" is only treated as a registry
+// host if it looks like one (contains a "." or ":", or is "localhost").
+func parseImageReference(image string) ImageReference {
+	ref := ImageReference{Registry: defaultRegistry, Tag: defaultTag}
+
+	remainder := image
+	if slash := strings.Index(remainder, "/"); slash != -1 {
+		candidate := remainder[:slash]
+		if looksLikeRegistryHost(candidate) {
+			ref.Registry = candidate
+			remainder = remainder[slash+1:]
+		}
+	}
+
+	if at := strings.LastIndex(remainder, "@"); at != -1 {
+		ref.Digest = remainder[at+1:]
+		remainder = remainder[:at]
+	} else if colon := strings.LastIndex(remainder, ":"); colon != -1 && !strings.Contains(remainder[colon:], "/") {
+		ref.Tag = remainder[colon+1:]
+		remainder = remainder[:colon]
+	}
+
+	if ref.Registry == defaultRegistry && !strings.Contains(remainder, "/") {
+		remainder = defaultNamespace + "/" + remainder
+	}
+	ref.Repository = remainder
+
+	return ref
+}
+
+func looksLikeRegistryHost(candidate string) bool {
+	return candidate == "localhost" || strings.ContainsAny(candidate, ".:")
+}
+
+// Reference returns the tag or digest to request from the registry.
+func (r ImageReference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}